@@ -126,6 +126,196 @@ func GetVP9Resloution(frame []byte) (width int, height int, err error) {
 	return width, height, nil
 }
 
+// VP9Header is the parsed result of a VP9 keyframe's uncompressed
+// header, as defined by Section 6.2 of the VP9 Bitstream & Decoding
+// Process Specification.
+type VP9Header struct {
+	Profile         uint8
+	ProfileReserved uint8 // reserved_zero bit read when Profile == 3
+
+	BitDepth uint8
+
+	ColorSpace   uint8
+	ColorRange   uint8
+	SubsamplingX uint8
+	SubsamplingY uint8
+
+	Width        int
+	Height       int
+	RenderWidth  int
+	RenderHeight int
+}
+
+// ParseVP9UncompressedHeader parses the uncompressed header of a VP9
+// keyframe, returning the color configuration and frame/render
+// dimensions needed to populate a vpcC record. Only keyframes carry
+// this information in full; non-keyframes return an error, whereas
+// GetVP9Resloution does not check frame_type at all.
+func ParseVP9UncompressedHeader(frame []byte) (header *VP9Header, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.New("failed to parse VP9 header, likely due to out-of-bounds read")
+		}
+	}()
+
+	bs := NewBitStream(frame)
+	header = &VP9Header{}
+
+	bs.SkipBits(2) // frame marker
+
+	profileLow := bs.GetBits(1)
+	profileHigh := bs.GetBits(1)
+	header.Profile = uint8((profileHigh << 1) | profileLow)
+	if header.Profile == 3 {
+		header.ProfileReserved = uint8(bs.GetBits(1))
+	}
+
+	showExistingFrame := bs.GetBits(1)
+	if showExistingFrame == 1 {
+		return nil, errors.New("vp9: show_existing_frame has no uncompressed header fields")
+	}
+
+	frameType := bs.GetBits(1)
+	bs.SkipBits(1) // show_frame
+	bs.SkipBits(1) // error_resilient_mode
+
+	if frameType != 0 {
+		return nil, errors.New("vp9: only key frame uncompressed header is supported")
+	}
+
+	bs.SkipBits(24) // sync code (0x49, 0x83, 0x42)
+
+	// --- Color Config ---
+	if header.Profile >= 2 {
+		highBitdepth := bs.GetBits(1)
+		if highBitdepth == 1 {
+			twelveBit := bs.GetBits(1)
+			if twelveBit == 1 {
+				header.BitDepth = 12
+			} else {
+				header.BitDepth = 10
+			}
+		} else {
+			header.BitDepth = 8
+		}
+	} else {
+		header.BitDepth = 8
+	}
+
+	header.ColorSpace = uint8(bs.GetBits(3))
+	if header.ColorSpace != 7 { // 7 is CS_SRGB
+		header.ColorRange = uint8(bs.GetBits(1))
+		if header.Profile == 1 || header.Profile == 3 {
+			header.SubsamplingX = uint8(bs.GetBits(1))
+			header.SubsamplingY = uint8(bs.GetBits(1))
+			bs.SkipBits(1) // reserved_zero
+		} else {
+			header.SubsamplingX = 1
+			header.SubsamplingY = 1
+		}
+	} else {
+		header.ColorRange = 1 // sRGB implies full range
+		header.SubsamplingX = 0
+		header.SubsamplingY = 0
+		if header.Profile == 1 || header.Profile == 3 {
+			bs.SkipBits(1) // reserved_zero
+		}
+	}
+
+	// --- Frame Size ---
+	widthMinus1 := bs.GetBits(16)
+	heightMinus1 := bs.GetBits(16)
+	header.Width = int(widthMinus1) + 1
+	header.Height = int(heightMinus1) + 1
+
+	renderAndFrameSizeDifferent := bs.GetBits(1)
+	if renderAndFrameSizeDifferent == 1 {
+		renderWidthMinus1 := bs.GetBits(16)
+		renderHeightMinus1 := bs.GetBits(16)
+		header.RenderWidth = int(renderWidthMinus1) + 1
+		header.RenderHeight = int(renderHeightMinus1) + 1
+	} else {
+		header.RenderWidth = header.Width
+		header.RenderHeight = header.Height
+	}
+
+	return header, nil
+}
+
+// VP9Level identifies one row of the VP9 level table (Annex A of the
+// VP9 Bitstream & Decoding Process Specification).
+type VP9Level string
+
+const (
+	VP9Level1   VP9Level = "1"
+	VP9Level2   VP9Level = "2"
+	VP9Level3   VP9Level = "3"
+	VP9Level3_1 VP9Level = "3.1"
+	VP9Level4   VP9Level = "4"
+	VP9Level4_1 VP9Level = "4.1"
+	VP9Level5   VP9Level = "5"
+	VP9Level5_1 VP9Level = "5.1"
+	VP9Level5_2 VP9Level = "5.2"
+	VP9Level6   VP9Level = "6"
+	VP9Level6_1 VP9Level = "6.1"
+	VP9Level6_2 VP9Level = "6.2"
+)
+
+type vp9LevelLimit struct {
+	level             VP9Level
+	maxLumaPicSize    int64
+	maxLumaSampleRate int64
+}
+
+// vp9Levels is ordered by increasing capability; DeriveVP9Level returns
+// the first entry whose picture size and sample rate constraints cover
+// the requested width/height/framerate.
+var vp9Levels = []vp9LevelLimit{
+	{VP9Level1, 512 * 384, 512 * 384 * 30},
+	{VP9Level2, 1024 * 576, 1024 * 576 * 30},
+	{VP9Level3, 1280 * 768, 1280 * 768 * 30},
+	{VP9Level3_1, 1280 * 768, 1280 * 768 * 60},
+	{VP9Level4, 2048 * 1088, 2048 * 1088 * 30},
+	{VP9Level4_1, 2048 * 1088, 2048 * 1088 * 60},
+	{VP9Level5, 4096 * 2176, 4096 * 2176 * 30},
+	{VP9Level5_1, 4096 * 2176, 4096 * 2176 * 60},
+	{VP9Level5_2, 4096 * 2176, 4096 * 2176 * 120},
+	{VP9Level6, 8192 * 4352, 8192 * 4352 * 30},
+	{VP9Level6_1, 8192 * 4352, 8192 * 4352 * 60},
+	{VP9Level6_2, 8192 * 4352, 8192 * 4352 * 120},
+}
+
+// DeriveVP9Level returns the lowest VP9 level whose max luma picture
+// size and max luma sample rate cover width/height/framerate, falling
+// back to the highest defined level if none of them do.
+func DeriveVP9Level(width, height, framerate int) VP9Level {
+	picSize := int64(width) * int64(height)
+	sampleRate := picSize * int64(framerate)
+
+	for _, l := range vp9Levels {
+		if picSize <= l.maxLumaPicSize && sampleRate <= l.maxLumaSampleRate {
+			return l.level
+		}
+	}
+	return VP9Level6_2
+}
+
+// vp9ChromaSubsampling maps subsampling_x/subsampling_y, as read from
+// the uncompressed header's color config, to the chromaSubsampling
+// code of the vpcC record.
+func vp9ChromaSubsampling(x, y uint8) byte {
+	switch {
+	case x == 1 && y == 1:
+		return 1 // 4:2:0
+	case x == 1 && y == 0:
+		return 2 // 4:2:2
+	case x == 0 && y == 0:
+		return 3 // 4:4:4
+	default:
+		return 1
+	}
+}
+
 // The vpcC box is defined in the "VP Codec ISO Media File Format Binding" specification.
 // This implementation is based on version 1 of the specification for VP9.
 //
@@ -146,37 +336,24 @@ func GetVP9Resloution(frame []byte) (width int, height int, err error) {
 //	  unsigned int(8)     codecInitializationData[codecInitializationDataSize];
 //	}
 func CreateVP9VpcCExtradata(keyframe []byte) ([]byte, error) {
-	// Profile is in the first byte.
-	b := keyframe[0]
-	profile := (b >> 4) & 0x03
-	// This is only correct if the profile is < 3. A full implementation
-	// would need a bitstream parser to check the extra profile bit.
-	// We proceed with this for simplicity, consistent with the vp8 implementation.
+	header, err := ParseVP9UncompressedHeader(keyframe)
+	if err != nil {
+		return nil, err
+	}
 
 	// vpcC box for VP9
 	// The record is 8 bytes long without codecInitializationData
 	vpcc := make([]byte, 8)
 
 	// profile
-	vpcc[0] = profile
-	// level: not present in vp9 bitstream, use a default value.
-	// The spec suggests values like 10 for 1.0, etc. We use 0 as a generic default.
+	vpcc[0] = header.Profile
+	// level: not derivable from a single frame without a framerate, use
+	// DeriveVP9Level once the caller knows it.
 	vpcc[1] = 0
-	// bitDepth
-	var bitDepth byte = 8
-	if profile == 2 || profile == 3 {
-		// Profiles 2 and 3 are for 10/12 bit. We need to parse more to be sure.
-		// Default to 10 for simplicity.
-		bitDepth = 10
-	}
-
-	// chromaSubsampling: 0 for 4:2:0. Most common case.
-	// Profiles 0 and 2 are 4:2:0 only.
-	// Profiles 1 and 3 support more, but we default to 4:2:0.
-	var chromaSubsampling byte = 0 // 4:2:0
 
-	// videoFullRangeFlag: Default to studio range.
-	var videoFullRangeFlag byte = 0
+	bitDepth := header.BitDepth
+	chromaSubsampling := vp9ChromaSubsampling(header.SubsamplingX, header.SubsamplingY)
+	videoFullRangeFlag := header.ColorRange
 
 	// Pack bitDepth, chromaSubsampling, and videoFullRangeFlag into one byte
 	// bitDepth (4 bits), chromaSubsampling (3 bits), videoFullRangeFlag (1 bit)
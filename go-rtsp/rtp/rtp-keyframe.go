@@ -0,0 +1,96 @@
+package rtp
+
+// IsVP8RTPKeyframe reports whether payload (the RTP payload of a VP8
+// packet) starts a keyframe, without assembling the full frame. It
+// requires S=1 and PID=0 and checks the P bit of the VP8 payload header
+// on the byte immediately following the descriptor.
+func IsVP8RTPKeyframe(payload []byte) (bool, bool) {
+	if len(payload) < 1 {
+		return false, false
+	}
+
+	b := payload[0]
+	s := b&0x10 != 0 // S bit: start of a VP8 partition
+	pid := b & 0x07  // partition index
+	headerLength := 1
+
+	if b&0x80 != 0 { // X bit
+		if len(payload) < 2 {
+			return false, false
+		}
+		extHdr := payload[1]
+		headerLength++
+		if extHdr&0x80 != 0 { // I bit
+			if len(payload) < headerLength+1 {
+				return false, false
+			}
+			if payload[headerLength]&0x80 != 0 {
+				headerLength += 2
+			} else {
+				headerLength++
+			}
+		}
+		if extHdr&0x40 != 0 { // L bit
+			headerLength++
+		}
+		if extHdr&0x20 != 0 || extHdr&0x10 != 0 { // T or K bit
+			headerLength++
+		}
+	}
+
+	if !s || pid != 0 {
+		return false, true
+	}
+	if len(payload) < headerLength+1 {
+		return false, false
+	}
+
+	return payload[headerLength]&0x01 == 0, true
+}
+
+// IsVP9RTPKeyframe reports whether payload (the RTP payload of a VP9
+// packet) starts a keyframe, without assembling the full frame. It
+// requires B=1 and checks the VP9 uncompressed header's frame marker
+// and frame_type bit on the byte immediately following the descriptor.
+func IsVP9RTPKeyframe(payload []byte) (bool, bool) {
+	desc, headerLen, err := parseVP9Descriptor(payload)
+	if err != nil {
+		return false, false
+	}
+
+	if !desc.B {
+		return false, true
+	}
+	if len(payload) < headerLen+1 {
+		return false, false
+	}
+
+	b := payload[headerLen]
+	if b&0xC0 != 0x80 { // frame marker must be '10'
+		return false, true
+	}
+
+	// After the 2-bit frame marker and 2-bit profile, the next bits are
+	// show_existing_frame then frame_type. Profile 3 reads one extra
+	// reserved profile bit first, shifting both down by one bit.
+	profile := (b >> 4) & 0x03
+	if profile == 3 {
+		return b&0x04 == 0 && b&0x02 == 0, true // show_existing_frame, frame_type
+	}
+	return b&0x08 == 0 && b&0x04 == 0, true // show_existing_frame, frame_type
+}
+
+// IsKeyframe inspects the RTP payload of a packet for the given codec
+// ("vp8" or "vp9") and reports whether it starts a keyframe, without
+// assembling the full frame. known is false for unsupported codecs or
+// payloads too short to inspect.
+func IsKeyframe(codec string, payload []byte) (isKey bool, known bool) {
+	switch codec {
+	case "vp8":
+		return IsVP8RTPKeyframe(payload)
+	case "vp9":
+		return IsVP9RTPKeyframe(payload)
+	default:
+		return false, false
+	}
+}
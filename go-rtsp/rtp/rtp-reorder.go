@@ -0,0 +1,127 @@
+package rtp
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// GapEvent describes a run of sequence numbers the ReorderBuffer gave
+// up waiting for once its window was exhausted, so callers can drive
+// NACK/RTX against exactly those packets.
+type GapEvent struct {
+	Start uint16
+	End   uint16
+}
+
+// ReorderBuffer buffers out-of-order RTP packets and releases them to
+// OnPacket in ascending sequence order, with 16-bit wraparound handled
+// throughout. A packet is only declared lost (via OnGap) once more than
+// Window packets have been buffered without it arriving, instead of the first
+// time a sequence-number gap is observed. Window bounds the buffer by
+// packet count only; there is no time-based (playout delay) variant.
+type ReorderBuffer struct {
+	Window int // max number of packets held before a gap is forced
+
+	packets     map[uint16][]byte
+	initialized bool
+	nextSeq     uint16
+
+	onPacket func(pkt []byte)
+	onGap    func(gap GapEvent)
+}
+
+// NewReorderBuffer creates a ReorderBuffer holding up to window
+// out-of-order packets before declaring the oldest missing one lost.
+func NewReorderBuffer(window int) *ReorderBuffer {
+	if window <= 0 {
+		window = 128
+	}
+	return &ReorderBuffer{
+		Window:  window,
+		packets: make(map[uint16][]byte),
+	}
+}
+
+// SetOnPacket registers the callback invoked with each raw RTP packet,
+// in ascending sequence order.
+func (rb *ReorderBuffer) SetOnPacket(onPacket func(pkt []byte)) {
+	rb.onPacket = onPacket
+}
+
+// SetOnGap registers the callback invoked when the window is exhausted
+// and a run of sequence numbers is declared lost.
+func (rb *ReorderBuffer) SetOnGap(onGap func(gap GapEvent)) {
+	rb.onGap = onGap
+}
+
+// seqBefore reports whether a comes strictly before b on the 16-bit
+// RTP sequence number space, per RFC 3550's wraparound rules.
+func seqBefore(a, b uint16) bool {
+	return int16(a-b) < 0
+}
+
+// Push buffers a raw RTP packet by the sequence number in its header
+// and releases whatever run of in-order packets that unblocks.
+func (rb *ReorderBuffer) Push(pkt []byte) error {
+	if len(pkt) < 4 {
+		return errors.New("rtp packet too short to read sequence number")
+	}
+	seq := binary.BigEndian.Uint16(pkt[2:4])
+
+	if !rb.initialized {
+		rb.initialized = true
+		rb.nextSeq = seq
+	}
+
+	// Already released or given up on: delivering it now would break
+	// ascending order, so it is dropped.
+	if seqBefore(seq, rb.nextSeq) {
+		return nil
+	}
+
+	rb.packets[seq] = pkt
+	rb.release()
+
+	if len(rb.packets) > rb.Window {
+		rb.forceAdvance()
+	}
+
+	return nil
+}
+
+func (rb *ReorderBuffer) release() {
+	for {
+		pkt, ok := rb.packets[rb.nextSeq]
+		if !ok {
+			break
+		}
+		delete(rb.packets, rb.nextSeq)
+		if rb.onPacket != nil {
+			rb.onPacket(pkt)
+		}
+		rb.nextSeq++
+	}
+}
+
+// forceAdvance is called once the window is full: the packet at
+// nextSeq still hasn't shown up, so the gap up to the oldest buffered
+// packet is reported lost and delivery resumes from there.
+func (rb *ReorderBuffer) forceAdvance() {
+	found := false
+	var lowest uint16
+	for seq := range rb.packets {
+		if !found || seqBefore(seq, lowest) {
+			lowest = seq
+			found = true
+		}
+	}
+	if !found {
+		return
+	}
+
+	if rb.onGap != nil && lowest != rb.nextSeq {
+		rb.onGap(GapEvent{Start: rb.nextSeq, End: lowest - 1})
+	}
+	rb.nextSeq = lowest
+	rb.release()
+}
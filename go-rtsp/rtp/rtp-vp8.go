@@ -9,11 +9,13 @@ import (
 // RFC 7741
 type VP8UnPacker struct {
 	CommUnPacker
-	frameBuffer  *bytes.Buffer
-	timestamp    uint32
-	lastSequence uint16
-	lost         bool
-	building     bool
+	frameBuffer   *bytes.Buffer
+	timestamp     uint32
+	lastSequence  uint16
+	lost          bool
+	building      bool
+	reorderBuffer *ReorderBuffer
+	gapPending    bool
 }
 
 func NewVP8UnPacker() *VP8UnPacker {
@@ -22,7 +24,31 @@ func NewVP8UnPacker() *VP8UnPacker {
 	}
 }
 
+// SetReorderBuffer makes UnPack route raw packets through rb first, so
+// packets arriving out of order are reassembled in sequence order
+// instead of being treated as loss. Existing callers that never set a
+// reorder buffer are unaffected.
+func (unpacker *VP8UnPacker) SetReorderBuffer(rb *ReorderBuffer) {
+	rb.SetOnPacket(func(pkt []byte) {
+		unpacker.unpack(pkt)
+	})
+	rb.SetOnGap(func(gap GapEvent) {
+		// Recorded rather than applied directly: unpack's frame-boundary
+		// handling unconditionally clears lost for a new frame, which
+		// would otherwise discard this signal before it's ever observed.
+		unpacker.gapPending = true
+	})
+	unpacker.reorderBuffer = rb
+}
+
 func (unpacker *VP8UnPacker) UnPack(pkt []byte) error {
+	if unpacker.reorderBuffer != nil {
+		return unpacker.reorderBuffer.Push(pkt)
+	}
+	return unpacker.unpack(pkt)
+}
+
+func (unpacker *VP8UnPacker) unpack(pkt []byte) error {
 	pkg := &RtpPacket{}
 	if err := pkg.Decode(pkt); err != nil {
 		return err
@@ -46,10 +72,12 @@ func (unpacker *VP8UnPacker) UnPack(pkt []byte) error {
 		unpacker.timestamp = pkg.Header.Timestamp
 		unpacker.lastSequence = pkg.Header.SequenceNumber
 		unpacker.frameBuffer.Reset()
-		unpacker.lost = false
+		unpacker.lost = unpacker.gapPending
+		unpacker.gapPending = false
 	} else {
-		if unpacker.lastSequence+1 != pkg.Header.SequenceNumber {
+		if unpacker.lastSequence+1 != pkg.Header.SequenceNumber || unpacker.gapPending {
 			unpacker.lost = true
+			unpacker.gapPending = false
 		}
 	}
 
@@ -98,3 +126,137 @@ func (unpacker *VP8UnPacker) UnPack(pkt []byte) error {
 
 	return nil
 }
+
+// VP8Packer splits an encoded VP8 frame into RTP packets carrying the
+// RFC 7741 payload descriptor.
+type VP8Packer struct {
+	MTU int // max payload size per packet, including the VP8 descriptor
+
+	UsePictureID    bool // emit the I bit with a picture ID
+	LongPictureID   bool // use the 15-bit (M=1) picture ID form instead of 7-bit
+	UseTL0PICIDX    bool // emit the L bit with a TL0PICIDX
+	UseTIDAndKeyIdx bool // emit the T/K bits with TID/Y/KEYIDX
+
+	TID       uint8
+	LayerSync bool
+	KeyIdx    uint8
+
+	sequence  uint16
+	pictureID uint16
+	tl0PicIdx uint8
+}
+
+func NewVP8Packer(mtu int) *VP8Packer {
+	if mtu <= 0 {
+		mtu = 1200
+	}
+	return &VP8Packer{MTU: mtu}
+}
+
+// SetLayer sets the temporal layer id, layer sync flag and key index
+// propagated via the T/K extended header fields.
+func (packer *VP8Packer) SetLayer(tid uint8, layerSync bool, keyIdx uint8) {
+	packer.TID = tid
+	packer.LayerSync = layerSync
+	packer.KeyIdx = keyIdx
+}
+
+func (packer *VP8Packer) descriptor(first bool) []byte {
+	useExt := packer.UsePictureID || packer.UseTL0PICIDX || packer.UseTIDAndKeyIdx
+	desc := make([]byte, 1, 4)
+	if useExt {
+		desc[0] |= 0x80 // X bit
+	}
+	if first {
+		desc[0] |= 0x10 // S bit: start of a VP8 partition
+	}
+
+	if !useExt {
+		return desc
+	}
+
+	extHdr := byte(0)
+	if packer.UsePictureID {
+		extHdr |= 0x80 // I bit
+	}
+	if packer.UseTL0PICIDX {
+		extHdr |= 0x40 // L bit
+	}
+	if packer.UseTIDAndKeyIdx {
+		extHdr |= 0x30 // T and K bits
+	}
+	desc = append(desc, extHdr)
+
+	if packer.UsePictureID {
+		if packer.LongPictureID {
+			desc = append(desc, byte(0x80|((packer.pictureID>>8)&0x7f)), byte(packer.pictureID&0xff))
+		} else {
+			desc = append(desc, byte(packer.pictureID&0x7f))
+		}
+	}
+
+	if packer.UseTL0PICIDX {
+		desc = append(desc, packer.tl0PicIdx)
+	}
+
+	if packer.UseTIDAndKeyIdx {
+		b := (packer.TID & 0x03) << 6
+		if packer.LayerSync {
+			b |= 0x20
+		}
+		b |= packer.KeyIdx & 0x1f
+		desc = append(desc, b)
+	}
+
+	return desc
+}
+
+// Pack fragments frame into RTP packets of at most MTU bytes of
+// payload, emitting the configured extended descriptor fields and
+// setting the marker bit on the last packet.
+func (packer *VP8Packer) Pack(frame []byte, timestamp uint32, ssrc uint32, payloadType uint8) ([]*RtpPacket, error) {
+	if len(frame) == 0 {
+		return nil, errors.New("vp8 packer: empty frame")
+	}
+
+	maxPayload := packer.MTU - 6 // leave room for the largest descriptor form
+	if maxPayload <= 0 {
+		return nil, errors.New("vp8 packer: mtu too small")
+	}
+
+	var packets []*RtpPacket
+	for off := 0; off < len(frame); {
+		first := off == 0
+		desc := packer.descriptor(first)
+
+		chunk := maxPayload
+		if chunk > len(frame)-off {
+			chunk = len(frame) - off
+		}
+
+		payload := make([]byte, 0, len(desc)+chunk)
+		payload = append(payload, desc...)
+		payload = append(payload, frame[off:off+chunk]...)
+		off += chunk
+
+		pkt := &RtpPacket{
+			Header: RtpHeader{
+				Version:        2,
+				PayloadType:    payloadType,
+				SequenceNumber: packer.sequence,
+				Timestamp:      timestamp,
+				SSRC:           ssrc,
+			},
+			Payload: payload,
+		}
+		if off >= len(frame) {
+			pkt.Header.Marker = 1
+		}
+		packer.sequence++
+		packets = append(packets, pkt)
+	}
+
+	packer.pictureID++
+	packer.tl0PicIdx++
+	return packets, nil
+}
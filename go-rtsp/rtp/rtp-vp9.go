@@ -0,0 +1,561 @@
+package rtp
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/novartc/gomedia/go-codec"
+)
+
+// VP9Picture describes one picture entry of a scalability structure's
+// picture group (G=1), as defined by draft-ietf-payload-vp9.
+type VP9Picture struct {
+	TID   uint8
+	U     bool
+	PDiff []uint8
+}
+
+// VP9ScalabilityStructure is the SS (V bit) portion of the VP9 payload
+// descriptor, carried on keyframes and on the first packet of a frame
+// that changes the scalability structure.
+type VP9ScalabilityStructure struct {
+	NS       uint8 // number of spatial layers minus 1
+	Y        bool  // spatial resolutions present
+	G        bool  // picture group description present
+	Widths   []uint16
+	Heights  []uint16
+	Pictures []VP9Picture
+}
+
+// VP9Descriptor is the parsed form of the VP9 RTP payload descriptor
+// (draft-ietf-payload-vp9), covering both flexible (F=1) and
+// non-flexible (F=0) modes.
+type VP9Descriptor struct {
+	I bool // picture ID present
+	P bool // inter-picture predicted frame
+	L bool // layer indices present
+	F bool // flexible mode
+	B bool // start of frame
+	E bool // end of frame
+	V bool // scalability structure present
+	Z bool // not a reference for upper spatial layers
+
+	PictureID uint16
+
+	TID uint8 // temporal layer id
+	U   bool  // switching up point
+	SID uint8 // spatial layer id
+	D   bool  // inter-layer dependency used
+
+	TL0PICIDX uint8 // non-flexible mode only
+
+	PDiff []uint8 // flexible mode reference P_DIFFs, up to 3
+
+	SS *VP9ScalabilityStructure
+}
+
+// parseVP9Descriptor parses the VP9 payload descriptor at the start of
+// payload and returns the descriptor together with the number of bytes
+// it occupies.
+func parseVP9Descriptor(payload []byte) (*VP9Descriptor, int, error) {
+	if len(payload) < 1 {
+		return nil, 0, errors.New("vp9 rtp packet payload is empty")
+	}
+
+	desc := &VP9Descriptor{}
+	b := payload[0]
+	desc.I = b&0x80 != 0
+	desc.P = b&0x40 != 0
+	desc.L = b&0x20 != 0
+	desc.F = b&0x10 != 0
+	desc.B = b&0x08 != 0
+	desc.E = b&0x04 != 0
+	desc.V = b&0x02 != 0
+	desc.Z = b&0x01 != 0
+
+	off := 1
+
+	if desc.I {
+		if len(payload) < off+1 {
+			return nil, 0, errors.New("vp9 rtp packet: short picture id")
+		}
+		if payload[off]&0x80 == 0 {
+			desc.PictureID = uint16(payload[off] & 0x7f)
+			off++
+		} else {
+			if len(payload) < off+2 {
+				return nil, 0, errors.New("vp9 rtp packet: short 15-bit picture id")
+			}
+			desc.PictureID = (uint16(payload[off]&0x7f) << 8) | uint16(payload[off+1])
+			off += 2
+		}
+	}
+
+	if desc.L {
+		if len(payload) < off+1 {
+			return nil, 0, errors.New("vp9 rtp packet: short layer indices")
+		}
+		li := payload[off]
+		desc.TID = li >> 5
+		desc.U = li&0x10 != 0
+		desc.SID = (li >> 1) & 0x07
+		desc.D = li&0x01 != 0
+		off++
+
+		if !desc.F {
+			if len(payload) < off+1 {
+				return nil, 0, errors.New("vp9 rtp packet: short tl0picidx")
+			}
+			desc.TL0PICIDX = payload[off]
+			off++
+		}
+	}
+
+	if desc.F && desc.P {
+		for i := 0; i < 3; i++ {
+			if len(payload) < off+1 {
+				return nil, 0, errors.New("vp9 rtp packet: short pdiff")
+			}
+			p := payload[off]
+			off++
+			desc.PDiff = append(desc.PDiff, p>>1)
+			if p&0x01 == 0 {
+				break
+			}
+		}
+	}
+
+	if desc.V {
+		if len(payload) < off+1 {
+			return nil, 0, errors.New("vp9 rtp packet: short scalability structure")
+		}
+		ss := &VP9ScalabilityStructure{}
+		b := payload[off]
+		ss.NS = b >> 5
+		ss.Y = b&0x10 != 0
+		ss.G = b&0x08 != 0
+		off++
+
+		numSpatial := int(ss.NS) + 1
+
+		if ss.Y {
+			for i := 0; i < numSpatial; i++ {
+				if len(payload) < off+4 {
+					return nil, 0, errors.New("vp9 rtp packet: short ss resolution")
+				}
+				w := (uint16(payload[off]) << 8) | uint16(payload[off+1])
+				h := (uint16(payload[off+2]) << 8) | uint16(payload[off+3])
+				ss.Widths = append(ss.Widths, w)
+				ss.Heights = append(ss.Heights, h)
+				off += 4
+			}
+		}
+
+		if ss.G {
+			if len(payload) < off+1 {
+				return nil, 0, errors.New("vp9 rtp packet: short ss picture group size")
+			}
+			ng := int(payload[off])
+			off++
+			for i := 0; i < ng; i++ {
+				if len(payload) < off+1 {
+					return nil, 0, errors.New("vp9 rtp packet: short ss picture entry")
+				}
+				pb := payload[off]
+				off++
+				pic := VP9Picture{
+					TID: pb >> 5,
+					U:   pb&0x10 != 0,
+				}
+				r := int((pb >> 2) & 0x03)
+				for j := 0; j < r; j++ {
+					if len(payload) < off+1 {
+						return nil, 0, errors.New("vp9 rtp packet: short ss picture pdiff")
+					}
+					pic.PDiff = append(pic.PDiff, payload[off])
+					off++
+				}
+				ss.Pictures = append(ss.Pictures, pic)
+			}
+		}
+
+		desc.SS = ss
+	}
+
+	return desc, off, nil
+}
+
+// OnVP9Rtp is called for every received RTP packet together with its
+// parsed VP9 payload descriptor, so that callers can make SVC layer
+// filtering or forwarding decisions without waiting for a full frame.
+type OnVP9Rtp func(pkt *RtpPacket, desc *VP9Descriptor)
+
+// VP9UnPacker reassembles VP9 frames from RTP packets carrying the VP9
+// payload descriptor per draft-ietf-payload-vp9, in both flexible and
+// non-flexible mode.
+type VP9UnPacker struct {
+	CommUnPacker
+	frameBuffer  *bytes.Buffer
+	timestamp    uint32
+	lastSequence uint16
+	lost         bool
+	building     bool
+
+	onVP9Rtp      OnVP9Rtp
+	reorderBuffer *ReorderBuffer
+	gapPending    bool
+
+	layerFilterEnabled bool
+	maxSpatial         int
+	maxTemporal        int
+	subFrames          [][]byte
+}
+
+func NewVP9UnPacker() *VP9UnPacker {
+	return &VP9UnPacker{
+		frameBuffer: new(bytes.Buffer),
+	}
+}
+
+// SetOnVP9Rtp registers a callback invoked with the parsed VP9
+// descriptor for every RTP packet, in addition to onRtp.
+func (unpacker *VP9UnPacker) SetOnVP9Rtp(onVP9Rtp OnVP9Rtp) {
+	unpacker.onVP9Rtp = onVP9Rtp
+}
+
+// SetReorderBuffer makes UnPack route raw packets through rb first, so
+// packets arriving out of order are reassembled in sequence order
+// instead of being treated as loss. Existing callers that never set a
+// reorder buffer are unaffected.
+func (unpacker *VP9UnPacker) SetReorderBuffer(rb *ReorderBuffer) {
+	rb.SetOnPacket(func(pkt []byte) {
+		unpacker.unpack(pkt)
+	})
+	rb.SetOnGap(func(gap GapEvent) {
+		// Recorded rather than applied directly: unpack's frame-boundary
+		// handling unconditionally clears lost for a new frame, which
+		// would otherwise discard this signal before it's ever observed.
+		unpacker.gapPending = true
+	})
+	unpacker.reorderBuffer = rb
+}
+
+// SetLayerFilter restricts reassembly to spatial layers up to
+// maxSpatial and temporal layers up to maxTemporal, dropping packets
+// for higher layers before they reach the frame buffer. Once set,
+// onFrame fires on the E bit of the highest retained spatial layer
+// instead of the RTP marker, since in VP9 SVC the marker only fires on
+// the last spatial layer of the whole super-frame. When more than one
+// spatial layer is retained, the bytes passed to onFrame are a valid
+// VP9 super-frame (each retained layer plus a super-frame index).
+func (unpacker *VP9UnPacker) SetLayerFilter(maxSpatial, maxTemporal int) {
+	unpacker.layerFilterEnabled = true
+	unpacker.maxSpatial = maxSpatial
+	unpacker.maxTemporal = maxTemporal
+}
+
+func (unpacker *VP9UnPacker) UnPack(pkt []byte) error {
+	if unpacker.reorderBuffer != nil {
+		return unpacker.reorderBuffer.Push(pkt)
+	}
+	return unpacker.unpack(pkt)
+}
+
+func (unpacker *VP9UnPacker) unpack(pkt []byte) error {
+	pkg := &RtpPacket{}
+	if err := pkg.Decode(pkt); err != nil {
+		return err
+	}
+
+	desc, headerLen, err := parseVP9Descriptor(pkg.Payload)
+	if err != nil {
+		return err
+	}
+
+	if unpacker.onRtp != nil {
+		unpacker.onRtp(pkg)
+	}
+	if unpacker.onVP9Rtp != nil {
+		unpacker.onVP9Rtp(pkg, desc)
+	}
+
+	if unpacker.layerFilterEnabled && (int(desc.SID) > unpacker.maxSpatial || int(desc.TID) > unpacker.maxTemporal) {
+		return nil
+	}
+
+	newTimestamp := pkg.Header.Timestamp != unpacker.timestamp
+
+	if !unpacker.building || newTimestamp || desc.B {
+		if unpacker.building && unpacker.frameBuffer.Len() > 0 {
+			if unpacker.layerFilterEnabled {
+				unpacker.subFrames = append(unpacker.subFrames, append([]byte(nil), unpacker.frameBuffer.Bytes()...))
+			} else if unpacker.onFrame != nil {
+				unpacker.onFrame(unpacker.frameBuffer.Bytes(), unpacker.timestamp, true)
+			}
+		}
+		if newTimestamp {
+			unpacker.subFrames = nil
+		}
+		unpacker.building = true
+		unpacker.timestamp = pkg.Header.Timestamp
+		unpacker.lastSequence = pkg.Header.SequenceNumber
+		unpacker.frameBuffer.Reset()
+		unpacker.lost = unpacker.gapPending
+		unpacker.gapPending = false
+	} else {
+		if unpacker.lastSequence+1 != pkg.Header.SequenceNumber || unpacker.gapPending {
+			unpacker.lost = true
+			unpacker.gapPending = false
+		}
+	}
+
+	unpacker.lastSequence = pkg.Header.SequenceNumber
+
+	if len(pkg.Payload) < headerLen {
+		unpacker.lost = true
+	} else {
+		unpacker.frameBuffer.Write(pkg.Payload[headerLen:])
+	}
+
+	// In SVC mode the highest retained spatial layer is the one whose SID
+	// reaches maxSpatial, or any layer when there are no layer indices at
+	// all. The RTP marker, which in VP9 SVC only fires on the last spatial
+	// layer of the super-frame, also ends the frame, in case fewer spatial
+	// layers than requested are actually present in the stream.
+	lastRetainedLayer := unpacker.layerFilterEnabled &&
+		(pkg.Header.Marker == 1 || int(desc.SID) >= unpacker.maxSpatial || !desc.L)
+
+	switch {
+	case lastRetainedLayer && desc.E:
+		unpacker.subFrames = append(unpacker.subFrames, append([]byte(nil), unpacker.frameBuffer.Bytes()...))
+		if unpacker.onFrame != nil {
+			unpacker.onFrame(vp9EncodeSuperFrame(unpacker.subFrames), unpacker.timestamp, unpacker.lost)
+		}
+		unpacker.building = false
+		unpacker.frameBuffer.Reset()
+		unpacker.subFrames = nil
+	case !unpacker.layerFilterEnabled && (desc.E || pkg.Header.Marker == 1):
+		if unpacker.onFrame != nil {
+			unpacker.onFrame(unpacker.frameBuffer.Bytes(), unpacker.timestamp, unpacker.lost)
+		}
+		unpacker.building = false
+		unpacker.frameBuffer.Reset()
+	}
+
+	return nil
+}
+
+// vp9EncodeSuperFrame concatenates the retained spatial layers of a
+// frame and, when there is more than one, appends a VP9 super-frame
+// index per Annex B of the VP9 Bitstream & Decoding Process
+// Specification so the result is a valid super-frame for libvpx.
+func vp9EncodeSuperFrame(layers [][]byte) []byte {
+	if len(layers) == 0 {
+		return nil
+	}
+	if len(layers) == 1 {
+		return layers[0]
+	}
+
+	maxSize := 0
+	for _, l := range layers {
+		if len(l) > maxSize {
+			maxSize = len(l)
+		}
+	}
+	bytesPerFrameSize := 1
+	for maxSize >= 1<<(8*bytesPerFrameSize) {
+		bytesPerFrameSize++
+	}
+
+	marker := byte(0xC0) | byte(bytesPerFrameSize-1)<<3 | byte(len(layers)-1)
+
+	out := make([]byte, 0, maxSize*len(layers))
+	for _, l := range layers {
+		out = append(out, l...)
+	}
+
+	out = append(out, marker)
+	for _, l := range layers {
+		size := len(l)
+		for i := 0; i < bytesPerFrameSize; i++ {
+			out = append(out, byte(size>>(8*i)))
+		}
+	}
+	out = append(out, marker)
+
+	return out
+}
+
+// VP9Packer splits an encoded VP9 frame into RTP packets carrying the
+// VP9 payload descriptor, per draft-ietf-payload-vp9.
+type VP9Packer struct {
+	MTU int
+
+	FlexibleMode    bool // F=1 with reference PDIFFs instead of TL0PICIDX
+	UsePictureID    bool
+	UseLayerIndices bool
+	UseSS           bool // emit a scalability structure on keyframes
+
+	SID uint8 // spatial layer id
+	TID uint8 // temporal layer id
+	U   bool  // switching up point
+	D   bool  // inter-layer dependency used
+
+	RefDiffs []uint8 // P_DIFF values for flexible-mode inter frames, up to 3
+
+	sequence  uint16
+	pictureID uint16
+	tl0PicIdx uint8
+}
+
+func NewVP9Packer(mtu int) *VP9Packer {
+	if mtu <= 0 {
+		mtu = 1200
+	}
+	return &VP9Packer{MTU: mtu}
+}
+
+// SetLayer sets the spatial/temporal layer ids propagated via the L
+// extended header field.
+func (packer *VP9Packer) SetLayer(sid, tid uint8, u, d bool) {
+	packer.SID = sid
+	packer.TID = tid
+	packer.U = u
+	packer.D = d
+}
+
+// SetReferences sets the P_DIFF values (distance, in picture IDs, to
+// each reference frame) emitted on flexible-mode inter frames. At most
+// 3 are encoded, per draft-ietf-payload-vp9.
+func (packer *VP9Packer) SetReferences(diffs []uint8) {
+	packer.RefDiffs = diffs
+}
+
+func (packer *VP9Packer) descriptor(first, keyframe bool, frame []byte) ([]byte, error) {
+	desc := make([]byte, 1, 8)
+	if first {
+		desc[0] |= 0x08 // B bit
+	}
+	if packer.FlexibleMode {
+		desc[0] |= 0x10 // F bit
+	}
+	if packer.UsePictureID {
+		desc[0] |= 0x80 // I bit
+	}
+	if packer.UseLayerIndices {
+		desc[0] |= 0x20 // L bit
+	}
+	useSS := packer.UseSS && keyframe && first
+	if useSS {
+		desc[0] |= 0x02 // V bit
+	}
+	usesRefs := packer.FlexibleMode && !keyframe && len(packer.RefDiffs) > 0
+	if usesRefs {
+		desc[0] |= 0x40 // P bit
+	}
+
+	if packer.UsePictureID {
+		desc = append(desc, byte(0x80|((packer.pictureID>>8)&0x7f)), byte(packer.pictureID&0xff))
+	}
+
+	if packer.UseLayerIndices {
+		li := (packer.TID << 5) | (packer.SID << 1)
+		if packer.U {
+			li |= 0x10
+		}
+		if packer.D {
+			li |= 0x01
+		}
+		desc = append(desc, li)
+		if !packer.FlexibleMode {
+			desc = append(desc, packer.tl0PicIdx)
+		}
+	}
+
+	if usesRefs {
+		n := len(packer.RefDiffs)
+		if n > 3 {
+			n = 3
+		}
+		for i := 0; i < n; i++ {
+			b := (packer.RefDiffs[i] & 0x7f) << 1
+			if i < n-1 {
+				b |= 0x01 // N bit: another P_DIFF follows
+			}
+			desc = append(desc, b)
+		}
+	}
+
+	if useSS {
+		header, err := codec.ParseVP9UncompressedHeader(frame)
+		if err != nil {
+			return nil, err
+		}
+		// N_S=0 (one spatial layer), Y=1 (resolution present), G=0.
+		desc = append(desc, 0x10)
+		desc = append(desc, byte(header.Width>>8), byte(header.Width&0xff), byte(header.Height>>8), byte(header.Height&0xff))
+	}
+
+	return desc, nil
+}
+
+// Pack fragments frame into RTP packets of at most MTU bytes of
+// payload. keyframe indicates whether frame is a VP9 keyframe, used to
+// decide whether to synthesize a scalability structure when UseSS is
+// set. It sets B on the first packet of the frame and E plus the RTP
+// marker on the last.
+func (packer *VP9Packer) Pack(frame []byte, timestamp uint32, ssrc uint32, payloadType uint8, keyframe bool) ([]*RtpPacket, error) {
+	if len(frame) == 0 {
+		return nil, errors.New("vp9 packer: empty frame")
+	}
+
+	maxPayload := packer.MTU - 14 // leave room for the largest descriptor form
+	if maxPayload <= 0 {
+		return nil, errors.New("vp9 packer: mtu too small")
+	}
+
+	var packets []*RtpPacket
+	for off := 0; off < len(frame); {
+		first := off == 0
+		desc, err := packer.descriptor(first, keyframe, frame)
+		if err != nil {
+			return nil, err
+		}
+
+		chunk := maxPayload
+		if chunk > len(frame)-off {
+			chunk = len(frame) - off
+		}
+
+		last := off+chunk >= len(frame)
+		if last {
+			desc[0] |= 0x04 // E bit
+		}
+
+		payload := make([]byte, 0, len(desc)+chunk)
+		payload = append(payload, desc...)
+		payload = append(payload, frame[off:off+chunk]...)
+		off += chunk
+
+		pkt := &RtpPacket{
+			Header: RtpHeader{
+				Version:        2,
+				PayloadType:    payloadType,
+				SequenceNumber: packer.sequence,
+				Timestamp:      timestamp,
+				SSRC:           ssrc,
+			},
+			Payload: payload,
+		}
+		if last {
+			pkt.Header.Marker = 1
+		}
+		packer.sequence++
+		packets = append(packets, pkt)
+	}
+
+	packer.pictureID++
+	packer.tl0PicIdx++
+	return packets, nil
+}